@@ -0,0 +1,151 @@
+package kafkalib
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/joho/godotenv"
+)
+
+// Config holds the settings needed to construct a Consumer or Producer.
+//
+// It replaces the old pattern of reading os.Getenv directly inside
+// Consume/Produce, so callers can run multiple independent consumers or
+// producers in one process without env collisions.
+type Config struct {
+	Brokers string
+
+	SecurityProtocol string
+	SASLMechanism    string
+	SASLUsername     string
+	SASLPassword     string
+	TLSCAFile        string
+
+	SchemaRegistryURL      string
+	SchemaRegistryUsername string
+	SchemaRegistryPassword string
+
+	ConsumerGroupID string
+	Topics          []string
+
+	MaxPollIntervalMs    int
+	SessionTimeoutMs     int
+	AutoCommitIntervalMs int
+	AutoOffsetReset      string
+
+	// PollTimeoutMs bounds each call ConsumeContext makes to the
+	// underlying consumer's Poll, so it can notice ctx cancellation
+	// promptly. Defaults to 100ms.
+	PollTimeoutMs int
+	// ShutdownGracePeriod bounds how long ConsumeContext waits for
+	// in-flight handlers to finish once ctx is cancelled. Defaults to
+	// 30s.
+	ShutdownGracePeriod time.Duration
+
+	// ManualCommit switches ConsumeContext to at-least-once delivery:
+	// auto-commit is disabled and an offset is only committed once its
+	// handler returns nil, or once its retries are exhausted (after the
+	// message is routed to DeadLetterTopic, if set). That offset is
+	// committed even when dead-lettering fails. Auto-commit is disabled
+	// for the whole consumer, so Consume, which commits nothing itself,
+	// commits no offsets in this mode.
+	ManualCommit bool
+	// MaxRetries is how many times a failing handler is retried, with
+	// RetryBackoff between attempts, before the message is given up on
+	// (and, in ManualCommit mode, dead-lettered). Defaults to 3.
+	MaxRetries int
+	// RetryBackoff scales linearly with attempt number between handler
+	// retries. Defaults to 500ms.
+	RetryBackoff time.Duration
+	// DeadLetterTopic is produced to, via the consumer's own producer,
+	// once a message exhausts MaxRetries. Headers carry the original
+	// topic/partition/offset and the last handler error. Left empty,
+	// messages that exhaust their retries are dropped with an error
+	// instead.
+	DeadLetterTopic string
+
+	// AutoCreate makes Producer ensure a topic exists (via a
+	// TopicManager, using TopicSpec) the first time it produces to it.
+	AutoCreate bool
+	// TopicSpec is the shape new topics are created with when
+	// AutoCreate is enabled.
+	TopicSpec TopicSpec
+	// TopicMetadataRefreshInterval controls how often a TopicManager
+	// refreshes its known-topic cache from broker metadata. Defaults to
+	// 10 minutes.
+	TopicMetadataRefreshInterval time.Duration
+}
+
+// brokerConfigMap builds the kafka.ConfigMap entries shared by every
+// client (consumer, producer, admin client) built from cfg: the broker
+// list and any SASL/TLS security settings. Callers add whatever
+// client-specific keys they need on top.
+func (cfg Config) brokerConfigMap() kafka.ConfigMap {
+	cm := kafka.ConfigMap{
+		"bootstrap.servers": cfg.Brokers,
+		"security.protocol": cfg.SecurityProtocol,
+		"sasl.mechanism":    cfg.SASLMechanism,
+		"sasl.username":     cfg.SASLUsername,
+		"sasl.password":     cfg.SASLPassword,
+	}
+	if cfg.TLSCAFile != "" {
+		cm.SetKey("ssl.ca.location", cfg.TLSCAFile)
+	}
+	return cm
+}
+
+// ConfigFromEnv builds a Config from the KAFKA_* environment variables,
+// loading a .env file first if one is present. It exists for callers
+// migrating from the old env-only API; new code should construct a
+// Config directly.
+func ConfigFromEnv() (Config, error) {
+	_ = godotenv.Load()
+
+	cfg := Config{
+		Brokers:                os.Getenv("KAFKA_BROKER_URL"),
+		SecurityProtocol:       os.Getenv("KAFKA_BROKER_SECURITY_PROTOCOL"),
+		SASLMechanism:          os.Getenv("KAFKA_BROKER_SASL_MECHANISM"),
+		SASLUsername:           os.Getenv("KAFKA_BROKER_SASL_USERNAME"),
+		SASLPassword:           os.Getenv("KAFKA_BROKER_SASL_PASSWORD"),
+		TLSCAFile:              os.Getenv("KAFKA_BROKER_TLS_CA_FILE"),
+		SchemaRegistryURL:      os.Getenv("KAFKA_SCHEMA_REGISTRY_URL"),
+		SchemaRegistryUsername: os.Getenv("KAFKA_SCHEMA_REGISTRY_USERNAME"),
+		SchemaRegistryPassword: os.Getenv("KAFKA_SCHEMA_REGISTRY_PASSWORD"),
+		ConsumerGroupID:        os.Getenv("KAFKA_CONSUMER_GROUP_ID"),
+		AutoOffsetReset:        os.Getenv("KAFKA_AUTO_OFFSET_RESET"),
+	}
+
+	if topic := os.Getenv("KAFKA_TOPIC"); topic != "" {
+		cfg.Topics = []string{topic}
+	}
+
+	var err error
+	if cfg.MaxPollIntervalMs, err = intEnv("KAFKA_MAX_POLL_INTERVAL", 300000); err != nil {
+		return Config{}, err
+	}
+	if cfg.SessionTimeoutMs, err = intEnv("KAFKA_SESSION_TIMEOUT_MS", 30000); err != nil {
+		return Config{}, err
+	}
+	if cfg.AutoCommitIntervalMs, err = intEnv("KAFKA_AUTO_COMMIT_INTERVAL_MS", 5000); err != nil {
+		return Config{}, err
+	}
+
+	if cfg.ConsumerGroupID == "" {
+		cfg.ConsumerGroupID = "default"
+	}
+	if cfg.AutoOffsetReset == "" {
+		cfg.AutoOffsetReset = "latest"
+	}
+
+	return cfg, nil
+}
+
+func intEnv(key string, def int) (int, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	return strconv.Atoi(v)
+}
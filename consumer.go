@@ -1,132 +1,388 @@
 package kafkalib
 
 import (
-	"encoding/binary"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"os"
+	"strconv"
+	"sync"
+	"time"
 
-	"github.com/joho/godotenv"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
 	"github.com/riferrei/srclient"
-	"gopkg.in/confluentinc/confluent-kafka-go.v1/kafka"
 )
 
-var schemaRegistryClient *srclient.SchemaRegistryClient
-var schemaRegistryUrl string
+const (
+	defaultPollTimeout         = 100 * time.Millisecond
+	defaultShutdownGracePeriod = 30 * time.Second
+	defaultMaxRetries          = 3
+	defaultRetryBackoff        = 500 * time.Millisecond
 
-// Consume will consume messages from a topic.
-//
-// Errors while consuming will be printed
-// The messages will then be passed into `f`
-// If `f` throws a panic it will be caught and handled
-//
-func Consume(f func(*kafka.Message)) {
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal(err)
-	}
+	// partitionQueueSize bounds how many polled-but-not-yet-dispatched
+	// messages a single partition's worker can queue up, so a slow
+	// partition applies backpressure to Poll instead of buffering
+	// unboundedly.
+	partitionQueueSize = 16
+)
 
-	MaxPollInt := os.Getenv("KAFKA_MAX_POLL_INTERVAL")
-	if MaxPollInt == "" {
-		MaxPollInt = "300000"
-	}
+// partitionKey identifies the partition a message belongs to, so
+// ConsumeContext can dispatch messages for the same partition to the
+// same worker and keep their commits in order.
+type partitionKey struct {
+	topic     string
+	partition int32
+}
 
-	SessTimeout := os.Getenv("KAFKA_SESSION_TIMEOUT_MS")
-	if SessTimeout == "" {
-		SessTimeout = "30000"
+func keyFor(msg *kafka.Message) partitionKey {
+	topic := ""
+	if msg.TopicPartition.Topic != nil {
+		topic = *msg.TopicPartition.Topic
 	}
+	return partitionKey{topic: topic, partition: msg.TopicPartition.Partition}
+}
 
-	AutoCommitInt := os.Getenv("KAFKA_AUTO_COMMIT_INTERVAL_MS")
-	if AutoCommitInt == "" {
-		AutoCommitInt = "5000"
-	}
+// Consumer wraps a kafka.Consumer together with the Deserializer used to
+// decode schema-registry-encoded message values.
+type Consumer struct {
+	kafka        *kafka.Consumer
+	deserializer Deserializer
 
-	cm := kafka.ConfigMap{
-		"bootstrap.servers":       os.Getenv("KAFKA_BROKER_URL"),
-		"security.protocol":       os.Getenv("KAFKA_BROKER_SECURITY_PROTOCOL"),
-		"sasl.mechanism":          os.Getenv("KAFKA_BROKER_SASL_MECHANISM"),
-		"sasl.username":           os.Getenv("KAFKA_BROKER_SASL_USERNAME"),
-		"sasl.password":           os.Getenv("KAFKA_BROKER_SASL_PASSWORD"),
-		"max.poll.interval.ms":    MaxPollInt,
-		"session.timeout.ms":      SessTimeout,
-		"auto.commit.interval.ms": AutoCommitInt,
-		"auto.offset.reset":       "latest",
-		"group.id":                "default",
-	}
+	pollTimeout         time.Duration
+	shutdownGracePeriod time.Duration
 
-	GroupId := os.Getenv("KAFKA_CONSUMER_GROUP_ID")
-	if GroupId != "" {
-		cm.SetKey("group.id", GroupId)
-	}
+	manualCommit    bool
+	maxRetries      int
+	retryBackoff    time.Duration
+	deadLetterTopic string
+	dlt             *Producer
+}
+
+// NewConsumer builds a Consumer from cfg and subscribes it to cfg.Topics.
+func NewConsumer(cfg Config) (*Consumer, error) {
+	cm := cfg.brokerConfigMap()
+	cm.SetKey("max.poll.interval.ms", cfg.MaxPollIntervalMs)
+	cm.SetKey("session.timeout.ms", cfg.SessionTimeoutMs)
+	cm.SetKey("auto.commit.interval.ms", cfg.AutoCommitIntervalMs)
+	cm.SetKey("auto.offset.reset", cfg.AutoOffsetReset)
+	cm.SetKey("group.id", cfg.ConsumerGroupID)
+	cm.SetKey("enable.auto.commit", !cfg.ManualCommit)
 
 	log.Println("Creating consumer")
 	c, err := kafka.NewConsumer(&cm)
-	log.Printf("brokerUrl: %s\n", cm["bootstrap.servers"])
-
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
+	log.Printf("brokerUrl: %s\n", cfg.Brokers)
 
-	defer c.Close()
+	if err := c.SubscribeTopics(cfg.Topics, nil); err != nil {
+		c.Close()
+		return nil, err
+	}
+	log.Println("Subscribed to topics")
 
-	c.SubscribeTopics([]string{os.Getenv("KAFKA_TOPIC")}, nil)
+	pollTimeout := time.Duration(cfg.PollTimeoutMs) * time.Millisecond
+	if pollTimeout <= 0 {
+		pollTimeout = defaultPollTimeout
+	}
+	shutdownGracePeriod := cfg.ShutdownGracePeriod
+	if shutdownGracePeriod <= 0 {
+		shutdownGracePeriod = defaultShutdownGracePeriod
+	}
+	maxRetries := cfg.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = defaultMaxRetries
+	}
+	retryBackoff := cfg.RetryBackoff
+	if retryBackoff <= 0 {
+		retryBackoff = defaultRetryBackoff
+	}
 
-	log.Println("Subscribed to topics")
+	consumer := &Consumer{
+		kafka:               c,
+		pollTimeout:         pollTimeout,
+		shutdownGracePeriod: shutdownGracePeriod,
+		manualCommit:        cfg.ManualCommit,
+		maxRetries:          maxRetries,
+		retryBackoff:        retryBackoff,
+		deadLetterTopic:     cfg.DeadLetterTopic,
+	}
 
-	schemaRegistryUrl = os.Getenv("KAFKA_SCHEMA_REGISTRY_URL")
-	if schemaRegistryUrl != "" {
+	if cfg.SchemaRegistryURL != "" {
 		log.Println("Creating schema registry client")
-		schemaRegistryClient = srclient.CreateSchemaRegistryClient(os.Getenv("KAFKA_SCHEMA_REGISTRY_URL"))
-		schemaRegistryUsername := os.Getenv("KAFKA_SCHEMA_REGISTRY_USERNAME")
-		schemaRegistryPassword := os.Getenv("KAFKA_SCHEMA_REGISTRY_PASSWORD")
-		if schemaRegistryUsername != "" && schemaRegistryPassword != "" {
-			schemaRegistryClient.SetCredentials(schemaRegistryUsername, schemaRegistryPassword)
+		schemaReg := srclient.CreateSchemaRegistryClient(cfg.SchemaRegistryURL)
+		if cfg.SchemaRegistryUsername != "" && cfg.SchemaRegistryPassword != "" {
+			schemaReg.SetCredentials(cfg.SchemaRegistryUsername, cfg.SchemaRegistryPassword)
 		}
+		consumer.deserializer = NewSchemaDeserializer(schemaReg)
 	}
 
+	if cfg.ManualCommit && cfg.DeadLetterTopic != "" {
+		dlt, err := NewProducer(cfg)
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("creating dead-letter producer: %w", err)
+		}
+		consumer.dlt = dlt
+	}
+
+	return consumer, nil
+}
+
+// Close closes the underlying kafka consumer and, if one was created for
+// dead-lettering, its producer.
+func (c *Consumer) Close() error {
+	if c.dlt != nil {
+		c.dlt.Close()
+	}
+	return c.kafka.Close()
+}
+
+// Consume will consume messages from a topic.
+//
+// Errors while consuming will be printed
+// The messages will then be passed into `f`
+// If `f` throws a panic it will be caught and handled
+func (c *Consumer) Consume(f func(*kafka.Message)) {
 	log.Println("Listening for messages")
 
 	for {
-		msg, err := c.ReadMessage(-1)
+		msg, err := c.kafka.ReadMessage(-1)
 		log.Println("New message received")
 		if err != nil {
 			// The client will automatically try to recover from all errors.
 			log.Printf("Consumer error: %v (%v)\n", err, msg)
 			continue
 		}
-		processMessage(msg, f)
+		c.processMessage(msg, f)
 	}
 }
 
-// decodeMessageValue will decode the kafka message value using the set up
-// schema registry based on environment variables
-func decodeMessageValue(msg *kafka.Message) {
-	if cap(msg.Value) < 6 {
-		log.Printf("Failed to get schema id from message: %s\n", string(msg.Value))
-		return
+// ConsumeContext consumes messages until ctx is cancelled, dispatching
+// each one to f. Unlike Consume, it polls with a bounded timeout so it
+// can observe ctx.Done() promptly, and it waits for in-flight handlers
+// to finish (up to the consumer's shutdown grace period) before
+// returning. Handler errors are collected and returned together as a
+// single joined error.
+//
+// Messages for the same partition are dispatched to the same worker
+// goroutine and processed in the order Poll returned them; different
+// partitions are processed concurrently. This matters in ManualCommit
+// mode: CommitMessage commits a per-partition high-water mark, so
+// committing offset 7 from one goroutine while offset 5 is still being
+// retried on another would silently skip offset 5 on restart. Keeping
+// each partition sequential makes commits monotonic.
+func (c *Consumer) ConsumeContext(ctx context.Context, f func(context.Context, *kafka.Message) error) error {
+	log.Println("Listening for messages")
+
+	var mu sync.Mutex
+	var handlerErrs error
+
+	workers := make(map[partitionKey]chan *kafka.Message)
+	var workersWG sync.WaitGroup
+
+	workerFor := func(key partitionKey) chan<- *kafka.Message {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if ch, ok := workers[key]; ok {
+			return ch
+		}
+
+		ch := make(chan *kafka.Message, partitionQueueSize)
+		workers[key] = ch
+		workersWG.Add(1)
+		go func() {
+			defer workersWG.Done()
+			for msg := range ch {
+				if err := c.dispatchContext(ctx, msg, f); err != nil {
+					mu.Lock()
+					handlerErrs = errors.Join(handlerErrs, err)
+					mu.Unlock()
+				}
+			}
+		}()
+		return ch
 	}
-	schemaID := binary.BigEndian.Uint32(msg.Value[1:5])
-	schema, err := schemaRegistryClient.GetSchema(int(schemaID))
+
+	pollTimeoutMs := int(c.pollTimeout / time.Millisecond)
+
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		default:
+		}
+
+		ev := c.kafka.Poll(pollTimeoutMs)
+		if ev == nil {
+			continue
+		}
+
+		switch e := ev.(type) {
+		case *kafka.Message:
+			workerFor(keyFor(e)) <- e
+		case kafka.Error:
+			log.Printf("Consumer error: %v\n", e)
+		}
+	}
+
+	mu.Lock()
+	for _, ch := range workers {
+		close(ch)
+	}
+	mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		workersWG.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(c.shutdownGracePeriod):
+		mu.Lock()
+		handlerErrs = errors.Join(handlerErrs, fmt.Errorf("timed out after %s waiting for in-flight handlers", c.shutdownGracePeriod))
+		mu.Unlock()
+	}
+
+	return handlerErrs
+}
+
+// dispatchContext decodes msg if a deserializer is configured, then
+// calls f, retrying it on error up to maxRetries times and recovering
+// from and reporting any panic as an error. In ManualCommit mode, once
+// retries are exhausted the message is dead-lettered (if configured) and
+// its offset is committed either way, so a poison message doesn't block
+// the partition forever.
+func (c *Consumer) dispatchContext(ctx context.Context, msg *kafka.Message, f func(context.Context, *kafka.Message) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic in handler: %v", r)
+		}
+	}()
+
+	if decodeErr := c.decodeMessageValue(ctx, msg); decodeErr != nil {
+		err = fmt.Errorf("deserializing message: %w", decodeErr)
+	} else {
+		err = c.runWithRetries(ctx, msg, func(ctx context.Context, m *kafka.Message) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("panic in handler: %v", r)
+				}
+			}()
+			return f(ctx, m)
+		})
+	}
+
+	if !c.manualCommit {
+		return err
+	}
+
+	if err != nil {
+		if dltErr := c.sendToDeadLetter(msg, err); dltErr != nil {
+			err = errors.Join(err, dltErr)
+		}
+	}
+
+	// Commit regardless of whether the handler (or dead-lettering)
+	// failed: ManualCommit's whole point is that a poison message gets
+	// logged and moved past, not retried forever on every rebalance.
+	if _, commitErr := c.kafka.CommitMessage(msg); commitErr != nil {
+		err = errors.Join(err, fmt.Errorf("committing offset: %w", commitErr))
+	}
+
+	return err
+}
+
+// runWithRetries calls f, retrying on error up to c.maxRetries times
+// with a linear backoff between attempts.
+func (c *Consumer) runWithRetries(ctx context.Context, msg *kafka.Message, f func(context.Context, *kafka.Message) error) error {
+	var lastErr error
+
+	for attempt := 0; attempt <= c.maxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return errors.Join(lastErr, ctx.Err())
+			case <-time.After(c.retryBackoff * time.Duration(attempt)):
+			}
+		}
+
+		if lastErr = f(ctx, msg); lastErr == nil {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("handler failed after %d attempts: %w", c.maxRetries+1, lastErr)
+}
+
+// sendToDeadLetter produces msg to the consumer's dead-letter topic,
+// carrying the original topic/partition/offset and cause as headers.
+func (c *Consumer) sendToDeadLetter(msg *kafka.Message, cause error) error {
+	if c.dlt == nil {
+		return fmt.Errorf("no dead-letter topic configured, dropping message: %w", cause)
+	}
+
+	topic := ""
+	if msg.TopicPartition.Topic != nil {
+		topic = *msg.TopicPartition.Topic
+	}
+
+	headers := append([]kafka.Header{
+		{Key: "x-original-topic", Value: []byte(topic)},
+		{Key: "x-original-partition", Value: []byte(strconv.Itoa(int(msg.TopicPartition.Partition)))},
+		{Key: "x-original-offset", Value: []byte(msg.TopicPartition.Offset.String())},
+		{Key: "x-error", Value: []byte(cause.Error())},
+	}, msg.Headers...)
+
+	return c.dlt.produceRaw(c.deadLetterTopic, msg.Key, msg.Value, headers)
+}
+
+// decodeMessageValue replaces msg.Value with the result of running it
+// through the consumer's Deserializer, if one is configured.
+func (c *Consumer) decodeMessageValue(ctx context.Context, msg *kafka.Message) error {
+	if c.deserializer == nil {
+		return nil
+	}
+
+	topic := ""
+	if msg.TopicPartition.Topic != nil {
+		topic = *msg.TopicPartition.Topic
+	}
+
+	decoded, err := c.deserializer.Deserialize(ctx, topic, msg.Value)
 	if err != nil {
-		panic(fmt.Sprintf("Error getting the schema with id '%d' %s", schemaID, err))
+		return err
+	}
+
+	value, ok := decoded.([]byte)
+	if !ok {
+		value, err = json.Marshal(decoded)
+		if err != nil {
+			return fmt.Errorf("marshalling deserialized value: %w", err)
+		}
 	}
-	native, _, _ := schema.Codec().NativeFromBinary(msg.Value[5:])
-	value, _ := schema.Codec().TextualFromNative(nil, native)
 	msg.Value = value
+
+	return nil
 }
 
 // processMessage will dispatch a worker that calls `f`
 // It will also handle any panics that it throws
-func processMessage(msg *kafka.Message, f func(*kafka.Message)) {
+func (c *Consumer) processMessage(msg *kafka.Message, f func(*kafka.Message)) {
 	defer func() {
 		if err := recover(); err != nil {
 			log.Println("Panic occurred:", err)
 		}
 	}()
 
-	if schemaRegistryUrl != "" {
-		decodeMessageValue(msg)
+	if err := c.decodeMessageValue(context.Background(), msg); err != nil {
+		log.Printf("Failed to deserialize message: %v\n", err)
+		return
 	}
 
 	log.Println("Calling function with kafka message")
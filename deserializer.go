@@ -0,0 +1,137 @@
+package kafkalib
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/riferrei/srclient"
+)
+
+// Deserializer decodes a raw Kafka message value (or key) into a Go
+// value.
+type Deserializer interface {
+	Deserialize(ctx context.Context, topic string, data []byte) (any, error)
+}
+
+// DeserializerFunc adapts a plain function to a Deserializer.
+type DeserializerFunc func(ctx context.Context, topic string, data []byte) (any, error)
+
+// Deserialize calls f.
+func (f DeserializerFunc) Deserialize(ctx context.Context, topic string, data []byte) (any, error) {
+	return f(ctx, topic, data)
+}
+
+// SchemaDeserializer decodes Confluent-wire-format message values by
+// resolving the embedded schema ID against a schema registry and
+// dispatching to the Deserializer registered for that schema's type.
+// Avro and JSON Schema are handled out of the box; there is no built-in
+// Protobuf handler because decoding an arbitrary .proto schema into a Go
+// value requires knowing the generated message type, so callers should
+// Register one backed by their generated types. Messages that aren't in
+// the Confluent wire format (too short, or missing the magic byte) pass
+// through unchanged as raw bytes. Schema lookups are cached per ID by
+// the underlying srclient.SchemaRegistryClient, so repeated messages for
+// the same schema don't hit the registry or re-parse the codec.
+type SchemaDeserializer struct {
+	registry *srclient.SchemaRegistryClient
+
+	mu     sync.RWMutex
+	byType map[srclient.SchemaType]Deserializer
+}
+
+// NewSchemaDeserializer builds a SchemaDeserializer backed by registry,
+// with the built-in Avro and JSON Schema deserializers registered.
+func NewSchemaDeserializer(registry *srclient.SchemaRegistryClient) *SchemaDeserializer {
+	d := &SchemaDeserializer{
+		registry: registry,
+		byType:   make(map[srclient.SchemaType]Deserializer),
+	}
+	d.byType[srclient.Avro] = DeserializerFunc(d.deserializeAvro)
+	d.byType[srclient.Json] = DeserializerFunc(d.deserializeJSON)
+	return d
+}
+
+// Register installs deserializer as the handler for schema registry
+// content type contentType (srclient.Avro, srclient.Json,
+// srclient.Protobuf, ...), overriding any built-in handling.
+func (d *SchemaDeserializer) Register(contentType srclient.SchemaType, deserializer Deserializer) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.byType[contentType] = deserializer
+}
+
+// Deserialize implements Deserializer.
+func (d *SchemaDeserializer) Deserialize(ctx context.Context, topic string, data []byte) (any, error) {
+	if len(data) < confluentWireHeaderLen || data[0] != confluentMagicByte {
+		return data, nil
+	}
+
+	schema, err := d.registry.GetSchema(schemaIDFromWireFormat(data))
+	if err != nil {
+		return nil, fmt.Errorf("resolving schema %d: %w", schemaIDFromWireFormat(data), err)
+	}
+
+	schemaType := srclient.Avro
+	if t := schema.SchemaType(); t != nil {
+		schemaType = *t
+	}
+
+	d.mu.RLock()
+	handler, ok := d.byType[schemaType]
+	d.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no deserializer registered for schema type %q", schemaType)
+	}
+
+	return handler.Deserialize(ctx, topic, data)
+}
+
+func (d *SchemaDeserializer) deserializeAvro(_ context.Context, _ string, data []byte) (any, error) {
+	schemaID := schemaIDFromWireFormat(data)
+	schema, err := d.registry.GetSchema(schemaID)
+	if err != nil {
+		return nil, fmt.Errorf("resolving schema %d: %w", schemaID, err)
+	}
+
+	native, _, err := schema.Codec().NativeFromBinary(data[confluentWireHeaderLen:])
+	if err != nil {
+		return nil, fmt.Errorf("decoding avro binary: %w", err)
+	}
+
+	text, err := schema.Codec().TextualFromNative(nil, native)
+	if err != nil {
+		return nil, fmt.Errorf("converting avro value to json: %w", err)
+	}
+
+	return text, nil
+}
+
+func (d *SchemaDeserializer) deserializeJSON(_ context.Context, _ string, data []byte) (any, error) {
+	schemaID := schemaIDFromWireFormat(data)
+	schema, err := d.registry.GetSchema(schemaID)
+	if err != nil {
+		return nil, fmt.Errorf("resolving schema %d: %w", schemaID, err)
+	}
+
+	body := data[confluentWireHeaderLen:]
+
+	var decoded any
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		return nil, fmt.Errorf("decoding json body: %w", err)
+	}
+
+	if js := schema.JsonSchema(); js != nil {
+		if err := js.Validate(decoded); err != nil {
+			return nil, fmt.Errorf("value does not match json schema: %w", err)
+		}
+	}
+
+	return body, nil
+}
+
+func schemaIDFromWireFormat(data []byte) int {
+	return int(binary.BigEndian.Uint32(data[1:5]))
+}
@@ -1,49 +1,270 @@
 package kafkalib
 
 import (
+	"context"
 	"fmt"
-	"os"
 
 	"github.com/confluentinc/confluent-kafka-go/kafka"
-	"github.com/joho/godotenv"
+	"github.com/riferrei/srclient"
 )
 
-var producer *kafka.Producer
+// Producer wraps a kafka.Producer along with its delivery-report loop
+// and, if configured, the schema registry client used to encode
+// Avro/Protobuf/JSON-Schema message values and keys.
+type Producer struct {
+	kafka     *kafka.Producer
+	schemaReg *srclient.SchemaRegistryClient
 
-// Produce will produce a message to the kafka topic
-func Produce(topic string, msg string) error {
-	godotenv.Load()
+	topics     *TopicManager
+	topicSpec  TopicSpec
+	autoCreate bool
+}
+
+// NewProducer builds a Producer from cfg.
+func NewProducer(cfg Config) (*Producer, error) {
+	cm := cfg.brokerConfigMap()
+	p, err := kafka.NewProducer(&cm)
+	if err != nil {
+		return nil, err
+	}
+
+	producer := &Producer{kafka: p}
 
-	if producer.String() == "" {
-		var err error
-		producer, err = kafka.NewProducer(&kafka.ConfigMap{"bootstrap.servers": os.Getenv("KAFKA_BROKER_URL")})
+	if cfg.SchemaRegistryURL != "" {
+		producer.schemaReg = srclient.CreateSchemaRegistryClient(cfg.SchemaRegistryURL)
+		if cfg.SchemaRegistryUsername != "" && cfg.SchemaRegistryPassword != "" {
+			producer.schemaReg.SetCredentials(cfg.SchemaRegistryUsername, cfg.SchemaRegistryPassword)
+		}
+	}
+
+	if cfg.AutoCreate {
+		topics, err := NewTopicManager(cfg)
 		if err != nil {
-			return err
+			p.Close()
+			return nil, fmt.Errorf("creating topic manager: %w", err)
 		}
+		producer.topics = topics
+		producer.topicSpec = cfg.TopicSpec
+		producer.autoCreate = true
 	}
 
 	// Delivery report handler for produced messages
 	go func() {
-		for e := range producer.Events() {
+		for e := range p.Events() {
 			switch ev := e.(type) {
 			case *kafka.Message:
-				if ev.TopicPartition.Error != nil {
-					fmt.Printf("Delivery failed: %v\n", ev.TopicPartition)
-				} else {
-					fmt.Printf("Delivered message to %v\n", ev.TopicPartition)
-				}
+				logDelivery(ev)
 			}
 		}
 	}()
 
-	// Produce messages to topic (asynchronously)
-	producer.Produce(&kafka.Message{
-		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
-		Value:          []byte(msg),
-	}, nil)
+	return producer, nil
+}
 
-	// Wait for message deliveries before shutting down
-	producer.Flush(15 * 1000)
+// logDelivery reports the outcome of a produced message's delivery
+// report, in the same format regardless of whether it arrived on the
+// producer's shared Events() loop or, late, on a ProduceSync call's own
+// delivery channel.
+func logDelivery(msg *kafka.Message) {
+	if msg.TopicPartition.Error != nil {
+		fmt.Printf("Delivery failed: %v\n", msg.TopicPartition)
+	} else {
+		fmt.Printf("Delivered message to %v\n", msg.TopicPartition)
+	}
+}
+
+// Close flushes any outstanding produced messages, then closes the
+// underlying kafka producer and, if AutoCreate was enabled, its topic
+// manager.
+func (p *Producer) Close() {
+	p.kafka.Flush(15 * 1000)
+	if p.topics != nil {
+		p.topics.Close()
+	}
+	p.kafka.Close()
+}
+
+// Produce will produce a message to the kafka topic
+func (p *Producer) Produce(topic string, msg string) error {
+	return p.produceRaw(topic, nil, []byte(msg), nil)
+}
 
+// ensureTopic creates topic (when AutoCreate is enabled) if it doesn't
+// already exist.
+func (p *Producer) ensureTopic(ctx context.Context, topic string) error {
+	if !p.autoCreate {
+		return nil
+	}
+	if err := p.topics.EnsureTopic(ctx, topic, p.topicSpec); err != nil {
+		return fmt.Errorf("ensuring topic %q exists: %w", topic, err)
+	}
 	return nil
 }
+
+// produceRaw ensures topic exists (when AutoCreate is enabled), then
+// queues a message with the given key, value and headers and returns
+// immediately; its delivery report lands on the producer's shared
+// Events() loop. Callers that need to wait for delivery should use
+// ProduceSync instead, or Flush the producer (via Close) when shutting
+// down.
+func (p *Producer) produceRaw(topic string, key, value []byte, headers []kafka.Header) error {
+	if err := p.ensureTopic(context.Background(), topic); err != nil {
+		return err
+	}
+
+	return p.kafka.Produce(newMessage(topic, key, value, headers), nil)
+}
+
+// newMessage builds the kafka.Message produced to topic, shared by
+// produceRaw and ProduceSync so they stay in sync.
+func newMessage(topic string, key, value []byte, headers []kafka.Header) *kafka.Message {
+	return &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+		Key:            key,
+		Value:          value,
+		Headers:        headers,
+	}
+}
+
+// ProduceSync produces a message to topic and waits for its delivery
+// report (or ctx cancellation), returning the partition/offset it landed
+// at. Unlike Produce, it does not share the producer's long-lived
+// delivery-report goroutine: it gives librdkafka a dedicated channel for
+// this message's delivery report instead. If ctx is cancelled first, the
+// delivery report is still awaited and logged in the background (the
+// same way Produce's shared goroutine logs it), so a late delivery
+// failure isn't silently dropped.
+func (p *Producer) ProduceSync(ctx context.Context, topic string, key, value []byte, headers ...kafka.Header) (kafka.TopicPartition, error) {
+	if err := p.ensureTopic(ctx, topic); err != nil {
+		return kafka.TopicPartition{}, err
+	}
+
+	deliveryChan := make(chan kafka.Event, 1)
+
+	if err := p.kafka.Produce(newMessage(topic, key, value, headers), deliveryChan); err != nil {
+		return kafka.TopicPartition{}, err
+	}
+
+	return awaitDelivery(ctx, deliveryChan)
+}
+
+// awaitDelivery waits for a delivery report on deliveryChan, or for ctx
+// to be cancelled first. On cancellation it keeps waiting for the
+// report in the background and logs it once it arrives, rather than
+// dropping it silently.
+func awaitDelivery(ctx context.Context, deliveryChan <-chan kafka.Event) (kafka.TopicPartition, error) {
+	select {
+	case <-ctx.Done():
+		go func() {
+			if e := <-deliveryChan; e != nil {
+				if msg, ok := e.(*kafka.Message); ok {
+					logDelivery(msg)
+				}
+			}
+		}()
+		return kafka.TopicPartition{}, ctx.Err()
+	case e := <-deliveryChan:
+		msg, ok := e.(*kafka.Message)
+		if !ok {
+			return kafka.TopicPartition{}, fmt.Errorf("unexpected delivery event type %T", e)
+		}
+		if msg.TopicPartition.Error != nil {
+			return msg.TopicPartition, msg.TopicPartition.Error
+		}
+		return msg.TopicPartition, nil
+	}
+}
+
+// ProduceSchemaOption customizes a schema-encoded produce call.
+type ProduceSchemaOption func(*schemaProduceOptions)
+
+type schemaProduceOptions struct {
+	keySubject string
+	key        any
+
+	subjectNameStrategy *SubjectNameStrategy
+	record              string
+}
+
+// WithKey attaches a schema-encoded key, resolved under keySubject, to
+// the produced message. keySubject is ignored if WithSubjectNameStrategy
+// is also given; the strategy derives the key's subject instead.
+func WithKey(keySubject string, key any) ProduceSchemaOption {
+	return func(o *schemaProduceOptions) {
+		o.keySubject = keySubject
+		o.key = key
+	}
+}
+
+// WithSubjectNameStrategy derives the subject(s) a schema-encoded
+// produce call resolves from strategy and the record's name, instead of
+// the literal subject string passed to ProduceAvro/ProduceProtobuf/
+// ProduceJSONSchema (that argument is ignored when this option is
+// given). Use alongside WithKey to also derive the key's subject.
+func WithSubjectNameStrategy(strategy SubjectNameStrategy, record string) ProduceSchemaOption {
+	return func(o *schemaProduceOptions) {
+		o.subjectNameStrategy = &strategy
+		o.record = record
+	}
+}
+
+// ProduceAvro encodes value as Avro using subject's latest schema,
+// frames it in the Confluent wire format, and produces it to topic.
+// subject must already exist in the schema registry; this does not
+// register a new schema. Pass WithSubjectNameStrategy to derive subject
+// from topic and a record name instead of passing it literally.
+func (p *Producer) ProduceAvro(topic, subject string, value any, opts ...ProduceSchemaOption) error {
+	return p.produceWithSchema(topic, subject, srclient.Avro, value, opts...)
+}
+
+// ProduceProtobuf encodes value as Protobuf using subject's latest
+// schema, frames it in the Confluent wire format, and produces it to
+// topic. value must implement proto.Message. subject must already exist
+// in the schema registry; this does not register a new schema. Pass
+// WithSubjectNameStrategy to derive subject from topic and a record name
+// instead of passing it literally.
+func (p *Producer) ProduceProtobuf(topic, subject string, value any, opts ...ProduceSchemaOption) error {
+	return p.produceWithSchema(topic, subject, srclient.Protobuf, value, opts...)
+}
+
+// ProduceJSONSchema validates value against subject's latest JSON
+// Schema, frames the JSON encoding in the Confluent wire format, and
+// produces it to topic. subject must already exist in the schema
+// registry; this does not register a new schema. Pass
+// WithSubjectNameStrategy to derive subject from topic and a record name
+// instead of passing it literally.
+func (p *Producer) ProduceJSONSchema(topic, subject string, value any, opts ...ProduceSchemaOption) error {
+	return p.produceWithSchema(topic, subject, srclient.Json, value, opts...)
+}
+
+func (p *Producer) produceWithSchema(topic, subject string, schemaType srclient.SchemaType, value any, opts ...ProduceSchemaOption) error {
+	if p.schemaReg == nil {
+		return fmt.Errorf("kafkalib: producer has no schema registry configured")
+	}
+
+	var options schemaProduceOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	valueSubject, keySubject := subject, options.keySubject
+	if options.subjectNameStrategy != nil {
+		valueSubject = options.subjectNameStrategy.Subject(topic, options.record, false)
+		keySubject = options.subjectNameStrategy.Subject(topic, options.record, true)
+	}
+
+	valueBytes, err := encodeSchemaValue(p.schemaReg, valueSubject, schemaType, value)
+	if err != nil {
+		return fmt.Errorf("encoding value for subject %q: %w", valueSubject, err)
+	}
+
+	var keyBytes []byte
+	if options.key != nil {
+		keyBytes, err = encodeSchemaValue(p.schemaReg, keySubject, schemaType, options.key)
+		if err != nil {
+			return fmt.Errorf("encoding key for subject %q: %w", keySubject, err)
+		}
+	}
+
+	return p.produceRaw(topic, keyBytes, valueBytes, nil)
+}
@@ -0,0 +1,98 @@
+package kafkalib
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+func TestNewMessageBuildsExpectedFields(t *testing.T) {
+	headers := []kafka.Header{{Key: "h", Value: []byte("v")}}
+	msg := newMessage("topic", []byte("key"), []byte("value"), headers)
+
+	if got := *msg.TopicPartition.Topic; got != "topic" {
+		t.Fatalf("expected topic %q, got %q", "topic", got)
+	}
+	if msg.TopicPartition.Partition != kafka.PartitionAny {
+		t.Fatalf("expected PartitionAny, got %v", msg.TopicPartition.Partition)
+	}
+	if string(msg.Key) != "key" || string(msg.Value) != "value" {
+		t.Fatalf("unexpected key/value: %q/%q", msg.Key, msg.Value)
+	}
+	if len(msg.Headers) != 1 || msg.Headers[0].Key != "h" {
+		t.Fatalf("unexpected headers: %v", msg.Headers)
+	}
+}
+
+func TestAwaitDeliveryReturnsOnSuccessfulDelivery(t *testing.T) {
+	topic := "topic"
+	deliveryChan := make(chan kafka.Event, 1)
+	deliveryChan <- &kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: 3, Offset: 42}}
+
+	tp, err := awaitDelivery(context.Background(), deliveryChan)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if tp.Partition != 3 || tp.Offset != 42 {
+		t.Fatalf("unexpected topic partition: %+v", tp)
+	}
+}
+
+func TestAwaitDeliveryReturnsDeliveryError(t *testing.T) {
+	deliveryChan := make(chan kafka.Event, 1)
+	deliveryChan <- &kafka.Message{TopicPartition: kafka.TopicPartition{Error: errors.New("broker down")}}
+
+	_, err := awaitDelivery(context.Background(), deliveryChan)
+	if err == nil {
+		t.Fatal("expected delivery error")
+	}
+}
+
+func TestAwaitDeliveryReturnsCtxErrOnCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	deliveryChan := make(chan kafka.Event, 1)
+	tp, err := awaitDelivery(ctx, deliveryChan)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if tp != (kafka.TopicPartition{}) {
+		t.Fatalf("expected zero-value topic partition, got %+v", tp)
+	}
+
+	// The late delivery report must still be drained by the background
+	// goroutine awaitDelivery spawns on cancellation, not left to whoever
+	// reads deliveryChan next.
+	topic := "topic"
+	deliveryChan <- &kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic}}
+	time.Sleep(10 * time.Millisecond)
+	select {
+	case <-deliveryChan:
+		t.Fatal("expected the late delivery report to already be drained")
+	default:
+	}
+}
+
+func TestWithSubjectNameStrategyDerivesSubjects(t *testing.T) {
+	var options schemaProduceOptions
+	WithKey("literal-key-subject", "key-value")(&options)
+	WithSubjectNameStrategy(TopicRecordNameStrategy, "Order")(&options)
+
+	if options.subjectNameStrategy == nil {
+		t.Fatal("expected subjectNameStrategy to be set")
+	}
+
+	valueSubject := options.subjectNameStrategy.Subject("orders", options.record, false)
+	keySubject := options.subjectNameStrategy.Subject("orders", options.record, true)
+
+	if want := "orders-Order-value"; valueSubject != want {
+		t.Fatalf("expected value subject %q, got %q", want, valueSubject)
+	}
+	if want := "orders-Order-key"; keySubject != want {
+		t.Fatalf("expected key subject %q, got %q", want, keySubject)
+	}
+}
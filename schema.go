@@ -0,0 +1,118 @@
+package kafkalib
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	"github.com/riferrei/srclient"
+	"google.golang.org/protobuf/proto"
+)
+
+// SubjectNameStrategy controls how Confluent Schema Registry subject
+// names are derived from a topic and record name, mirroring the
+// strategies supported by Confluent's own serializers.
+type SubjectNameStrategy int
+
+const (
+	// TopicNameStrategy derives the subject from the topic name alone
+	// (e.g. "orders-value"). This is the Confluent default.
+	TopicNameStrategy SubjectNameStrategy = iota
+	// RecordNameStrategy derives the subject from the fully-qualified
+	// record name, independent of topic.
+	RecordNameStrategy
+	// TopicRecordNameStrategy derives the subject from both the topic
+	// and the record name.
+	TopicRecordNameStrategy
+)
+
+// Subject returns the schema registry subject name for a message on
+// topic, optionally qualified by record (the Avro/Protobuf record name),
+// for either the key or the value part of the message.
+func (s SubjectNameStrategy) Subject(topic, record string, isKey bool) string {
+	part := "value"
+	if isKey {
+		part = "key"
+	}
+
+	switch s {
+	case RecordNameStrategy:
+		return fmt.Sprintf("%s-%s", record, part)
+	case TopicRecordNameStrategy:
+		return fmt.Sprintf("%s-%s-%s", topic, record, part)
+	default:
+		return fmt.Sprintf("%s-%s", topic, part)
+	}
+}
+
+const (
+	confluentMagicByte     = 0x00
+	confluentWireHeaderLen = 5
+)
+
+// encodeConfluentWireFormat frames body in the Confluent wire format:
+// one magic byte (0x00), four big-endian bytes of schema ID, then body.
+func encodeConfluentWireFormat(schemaID int, body []byte) []byte {
+	out := make([]byte, confluentWireHeaderLen+len(body))
+	out[0] = confluentMagicByte
+	binary.BigEndian.PutUint32(out[1:5], uint32(schemaID))
+	copy(out[5:], body)
+	return out
+}
+
+// encodeSchemaValue resolves subject's latest schema from reg, encodes
+// value according to schemaType, and frames the result in the Confluent
+// wire format. It only resolves an existing schema; it does not register
+// one for subject on a miss, since ProduceAvro/ProduceProtobuf/
+// ProduceJSONSchema take a Go value to encode rather than a schema
+// definition, so there's nothing here to register. Subjects must already
+// exist in the registry before producing to them with schema encoding.
+func encodeSchemaValue(reg *srclient.SchemaRegistryClient, subject string, schemaType srclient.SchemaType, value any) ([]byte, error) {
+	schema, err := reg.GetLatestSchema(subject)
+	if err != nil {
+		return nil, fmt.Errorf("resolving latest schema for subject %q: %w", subject, err)
+	}
+
+	var body []byte
+	switch schemaType {
+	case srclient.Avro:
+		jsonBytes, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("marshalling value to json: %w", err)
+		}
+		native, _, err := schema.Codec().NativeFromTextual(jsonBytes)
+		if err != nil {
+			return nil, fmt.Errorf("converting json to avro native: %w", err)
+		}
+		body, err = schema.Codec().BinaryFromNative(nil, native)
+		if err != nil {
+			return nil, fmt.Errorf("encoding avro binary: %w", err)
+		}
+	case srclient.Json:
+		jsonBytes, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("marshalling value to json: %w", err)
+		}
+		var decoded any
+		if err := json.Unmarshal(jsonBytes, &decoded); err != nil {
+			return nil, fmt.Errorf("decoding json for validation: %w", err)
+		}
+		if err := schema.JsonSchema().Validate(decoded); err != nil {
+			return nil, fmt.Errorf("value does not match json schema: %w", err)
+		}
+		body = jsonBytes
+	case srclient.Protobuf:
+		msg, ok := value.(proto.Message)
+		if !ok {
+			return nil, fmt.Errorf("value must implement proto.Message for protobuf encoding, got %T", value)
+		}
+		body, err = proto.Marshal(msg)
+		if err != nil {
+			return nil, fmt.Errorf("encoding protobuf: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported schema type %q", schemaType)
+	}
+
+	return encodeConfluentWireFormat(schema.ID(), body), nil
+}
@@ -0,0 +1,140 @@
+package kafkalib
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+const defaultTopicMetadataRefreshInterval = 10 * time.Minute
+
+// TopicSpec describes the desired shape of a topic for EnsureTopic.
+type TopicSpec struct {
+	Partitions        int
+	ReplicationFactor int
+	RetentionMs       int64
+	CleanupPolicy     string
+	Configs           map[string]string
+}
+
+// TopicManager creates topics on demand and caches the set of topics
+// known to exist, refreshing that cache from broker metadata on a
+// background ticker instead of on every EnsureTopic call, so it's cheap
+// to call before every produce even with many topics.
+type TopicManager struct {
+	admin *kafka.AdminClient
+
+	refreshInterval time.Duration
+	known           sync.Map // topic name (string) -> struct{}
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewTopicManager builds a TopicManager from cfg and starts its
+// background metadata refresh ticker. Call Close to stop it.
+func NewTopicManager(cfg Config) (*TopicManager, error) {
+	cm := cfg.brokerConfigMap()
+	admin, err := kafka.NewAdminClient(&cm)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshInterval := cfg.TopicMetadataRefreshInterval
+	if refreshInterval <= 0 {
+		refreshInterval = defaultTopicMetadataRefreshInterval
+	}
+
+	tm := &TopicManager{
+		admin:           admin,
+		refreshInterval: refreshInterval,
+		stop:            make(chan struct{}),
+		done:            make(chan struct{}),
+	}
+
+	tm.refreshKnownTopics()
+	go tm.refreshLoop()
+
+	return tm, nil
+}
+
+// Close stops the background refresh loop and closes the admin client.
+func (tm *TopicManager) Close() {
+	close(tm.stop)
+	<-tm.done
+	tm.admin.Close()
+}
+
+func (tm *TopicManager) refreshLoop() {
+	defer close(tm.done)
+
+	ticker := time.NewTicker(tm.refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-tm.stop:
+			return
+		case <-ticker.C:
+			tm.refreshKnownTopics()
+		}
+	}
+}
+
+func (tm *TopicManager) refreshKnownTopics() {
+	metadata, err := tm.admin.GetMetadata(nil, true, 5000)
+	if err != nil {
+		log.Printf("Failed to refresh topic metadata: %v\n", err)
+		return
+	}
+
+	for topic := range metadata.Topics {
+		tm.known.Store(topic, struct{}{})
+	}
+}
+
+// EnsureTopic creates name with spec if it isn't already known to
+// exist. The known-topic cache is only refreshed from broker metadata in
+// the background, so repeated calls for an existing topic don't hit the
+// broker.
+func (tm *TopicManager) EnsureTopic(ctx context.Context, name string, spec TopicSpec) error {
+	if _, ok := tm.known.Load(name); ok {
+		return nil
+	}
+
+	config := make(map[string]string, len(spec.Configs)+2)
+	for k, v := range spec.Configs {
+		config[k] = v
+	}
+	if spec.RetentionMs > 0 {
+		config["retention.ms"] = strconv.FormatInt(spec.RetentionMs, 10)
+	}
+	if spec.CleanupPolicy != "" {
+		config["cleanup.policy"] = spec.CleanupPolicy
+	}
+
+	results, err := tm.admin.CreateTopics(ctx, []kafka.TopicSpecification{{
+		Topic:             name,
+		NumPartitions:     spec.Partitions,
+		ReplicationFactor: spec.ReplicationFactor,
+		Config:            config,
+	}})
+	if err != nil {
+		return fmt.Errorf("creating topic %q: %w", name, err)
+	}
+
+	for _, result := range results {
+		if result.Error.Code() != kafka.ErrNoError && result.Error.Code() != kafka.ErrTopicAlreadyExists {
+			return fmt.Errorf("creating topic %q: %w", name, result.Error)
+		}
+	}
+
+	tm.known.Store(name, struct{}{})
+
+	return nil
+}